@@ -0,0 +1,86 @@
+// Package ot implements conflict resolution for porymap's map-edit
+// broadcast commands. Each session keeps a monotonically increasing
+// revision counter; commands whose BaseRevision is stale relative to the
+// region they target are dropped instead of rebroadcast, so concurrent
+// edits to one region converge on whichever the server accepts first.
+package ot
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// OpType identifies the kind of map edit a Command represents.
+type OpType uint8
+
+// Supported map edit operations.
+const (
+	OpPaintTile OpType = iota
+	OpPaintBlock
+	OpFill
+)
+
+// Command is a single structured map-edit operation sent by a client.
+// BaseRevision is the session revision the client had observed when it
+// made the edit, used to detect conflicting concurrent edits.
+type Command struct {
+	Op           OpType
+	MapID        string
+	X, Y         int32
+	BaseRevision uint64
+	Payload      []byte
+}
+
+const headerSize = 1 + 4 + 8 + 8 // op + mapID length + x/y + base revision
+
+// Encode serializes cmd to its wire representation.
+func Encode(cmd Command) []byte {
+	buf := make([]byte, 0, headerSize+len(cmd.MapID)+len(cmd.Payload))
+	buf = append(buf, byte(cmd.Op))
+
+	mapIDLen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(mapIDLen, uint32(len(cmd.MapID)))
+	buf = append(buf, mapIDLen...)
+	buf = append(buf, cmd.MapID...)
+
+	coords := make([]byte, 8)
+	binary.LittleEndian.PutUint32(coords[0:4], uint32(cmd.X))
+	binary.LittleEndian.PutUint32(coords[4:8], uint32(cmd.Y))
+	buf = append(buf, coords...)
+
+	rev := make([]byte, 8)
+	binary.LittleEndian.PutUint64(rev, cmd.BaseRevision)
+	buf = append(buf, rev...)
+
+	return append(buf, cmd.Payload...)
+}
+
+// Decode parses a Command from its wire representation.
+func Decode(data []byte) (Command, error) {
+	if len(data) < 1+4 {
+		return Command{}, fmt.Errorf("ot: command too short (%d bytes)", len(data))
+	}
+
+	var cmd Command
+	cmd.Op = OpType(data[0])
+	data = data[1:]
+
+	mapIDLen := binary.LittleEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint64(len(data)) < uint64(mapIDLen)+16 {
+		return Command{}, fmt.Errorf("ot: truncated command")
+	}
+
+	cmd.MapID = string(data[:mapIDLen])
+	data = data[mapIDLen:]
+
+	cmd.X = int32(binary.LittleEndian.Uint32(data[0:4]))
+	cmd.Y = int32(binary.LittleEndian.Uint32(data[4:8]))
+	cmd.BaseRevision = binary.LittleEndian.Uint64(data[8:16])
+	// Copy rather than alias: data is typically a slice into a reused
+	// read buffer, and decoded commands are kept around (e.g. in a
+	// session's revision log for resync) well past the call to Decode.
+	cmd.Payload = append([]byte(nil), data[16:]...)
+
+	return cmd, nil
+}
@@ -0,0 +1,63 @@
+package ot
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	cases := []Command{
+		{Op: OpPaintTile, MapID: "Route101", X: 3, Y: 4, BaseRevision: 0, Payload: nil},
+		{Op: OpPaintBlock, MapID: "PalletTown", X: -5, Y: 12, BaseRevision: 42, Payload: []byte{1, 2, 3}},
+		{Op: OpFill, MapID: "", X: 0, Y: 0, BaseRevision: 7, Payload: []byte("metatile")},
+	}
+
+	for _, want := range cases {
+		got, err := Decode(Encode(want))
+		if err != nil {
+			t.Fatalf("Decode(Encode(%+v)) returned error: %v", want, err)
+		}
+		if got.Op != want.Op || got.MapID != want.MapID || got.X != want.X || got.Y != want.Y || got.BaseRevision != want.BaseRevision {
+			t.Fatalf("Decode(Encode(%+v)) = %+v", want, got)
+		}
+		if !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("Decode(Encode(%+v)).Payload = %v, want %v", want, got.Payload, want.Payload)
+		}
+	}
+}
+
+func TestDecodeTooShort(t *testing.T) {
+	if _, err := Decode(nil); err == nil {
+		t.Fatal("Decode(nil) expected error, got nil")
+	}
+	if _, err := Decode([]byte{byte(OpPaintTile)}); err == nil {
+		t.Fatal("Decode with only an op byte expected error, got nil")
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	encoded := Encode(Command{Op: OpPaintTile, MapID: "Route101", X: 1, Y: 1, BaseRevision: 1})
+	if _, err := Decode(encoded[:len(encoded)-1]); err == nil {
+		t.Fatal("Decode of truncated command expected error, got nil")
+	}
+}
+
+func TestDecodeCopiesPayload(t *testing.T) {
+	encoded := Encode(Command{Op: OpPaintTile, MapID: "Route101", X: 1, Y: 1, Payload: []byte("original")})
+
+	cmd, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+
+	// Mutating the source buffer after Decode must not change the
+	// decoded command: Payload is handed to long-lived callers (e.g. a
+	// session's revision log) well after the source buffer is reused.
+	for i := range encoded {
+		encoded[i] = 0xFF
+	}
+
+	if string(cmd.Payload) != "original" {
+		t.Fatalf("cmd.Payload = %q after mutating source buffer, want %q; Decode must copy", cmd.Payload, "original")
+	}
+}
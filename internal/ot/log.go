@@ -0,0 +1,92 @@
+package ot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Entry is a Command tagged with the revision the server assigned it.
+type Entry struct {
+	Revision uint64
+	Command  Command
+}
+
+// Log tracks the conflict-resolution state for a single session: a
+// monotonically increasing revision counter, the latest accepted
+// revision for each edited region, and the history needed to answer
+// Since queries for reconnecting clients.
+type Log struct {
+	mu       sync.Mutex
+	revision uint64
+	regions  map[string]uint64
+	history  []Entry
+}
+
+// NewLog creates an empty revision log.
+func NewLog() *Log {
+	return &Log{regions: map[string]uint64{}}
+}
+
+// Apply assigns cmd the next revision and records it, unless cmd's
+// BaseRevision is stale relative to the region it targets, in which case
+// it is dropped and ok is false.
+func (l *Log) Apply(cmd Command) (entry Entry, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	region := regionKey(cmd)
+	if cmd.BaseRevision < l.regions[region] {
+		return Entry{}, false
+	}
+
+	l.revision++
+	entry = Entry{Revision: l.revision, Command: cmd}
+	l.regions[region] = l.revision
+	l.history = append(l.history, entry)
+	return entry, true
+}
+
+// Since returns every entry with a revision greater than rev, in the
+// order they were applied.
+func (l *Log) Since(rev uint64) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	for _, e := range l.history {
+		if e.Revision > rev {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func regionKey(cmd Command) string {
+	return fmt.Sprintf("%s:%d:%d", cmd.MapID, cmd.X, cmd.Y)
+}
+
+// EncodeBroadcast tags entry's command with the revision the server
+// assigned it (an 8-byte little-endian prefix), for delivery to other
+// clients.
+func EncodeBroadcast(entry Entry) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, entry.Revision)
+	return append(buf, Encode(entry.Command)...)
+}
+
+// EncodeSnapshot serializes entries as a length-prefixed batch: a 4-byte
+// count, followed for each entry by a 4-byte length and its
+// EncodeBroadcast bytes.
+func EncodeSnapshot(entries []Entry) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(len(entries)))
+	for _, e := range entries {
+		frame := EncodeBroadcast(e)
+		frameLen := make([]byte, 4)
+		binary.LittleEndian.PutUint32(frameLen, uint32(len(frame)))
+		buf = append(buf, frameLen...)
+		buf = append(buf, frame...)
+	}
+	return buf
+}
@@ -0,0 +1,93 @@
+package ot
+
+import "testing"
+
+func TestLogApplyAssignsIncreasingRevisions(t *testing.T) {
+	log := NewLog()
+
+	entryA, ok := log.Apply(Command{MapID: "Route101", X: 1, Y: 1})
+	if !ok {
+		t.Fatal("first Apply on a fresh region should be accepted")
+	}
+	if entryA.Revision != 1 {
+		t.Fatalf("entryA.Revision = %d, want 1", entryA.Revision)
+	}
+
+	entryB, ok := log.Apply(Command{MapID: "Route101", X: 5, Y: 5, BaseRevision: entryA.Revision})
+	if !ok {
+		t.Fatal("Apply on a different region with a current base revision should be accepted")
+	}
+	if entryB.Revision != 2 {
+		t.Fatalf("entryB.Revision = %d, want 2", entryB.Revision)
+	}
+}
+
+func TestLogApplyDropsStaleRevisionForSameRegion(t *testing.T) {
+	log := NewLog()
+
+	first, ok := log.Apply(Command{MapID: "Route101", X: 1, Y: 1, BaseRevision: 0})
+	if !ok {
+		t.Fatal("first Apply on a fresh region should be accepted")
+	}
+
+	if _, ok := log.Apply(Command{MapID: "Route101", X: 1, Y: 1, BaseRevision: 0}); ok {
+		t.Fatal("second Apply with a stale base revision for the same region should be dropped")
+	}
+
+	if _, ok := log.Apply(Command{MapID: "Route101", X: 1, Y: 1, BaseRevision: first.Revision}); !ok {
+		t.Fatal("Apply with the current revision for the same region should be accepted")
+	}
+}
+
+func TestLogApplyTracksRegionsIndependently(t *testing.T) {
+	log := NewLog()
+
+	if _, ok := log.Apply(Command{MapID: "Route101", X: 1, Y: 1, BaseRevision: 0}); !ok {
+		t.Fatal("first Apply on region (1,1) should be accepted")
+	}
+
+	// A stale edit to a different tile on the same map isn't blocked by
+	// the unrelated region's revision bump.
+	if _, ok := log.Apply(Command{MapID: "Route101", X: 2, Y: 2, BaseRevision: 0}); !ok {
+		t.Fatal("first Apply on region (2,2) should be accepted even though another region has since advanced")
+	}
+}
+
+func TestLogSinceReturnsEntriesInOrder(t *testing.T) {
+	log := NewLog()
+
+	var applied []Entry
+	for i := 0; i < 3; i++ {
+		entry, ok := log.Apply(Command{MapID: "Route101", X: int32(i), Y: int32(i)})
+		if !ok {
+			t.Fatalf("Apply #%d should be accepted", i)
+		}
+		applied = append(applied, entry)
+	}
+
+	since := log.Since(applied[0].Revision)
+	if len(since) != 2 {
+		t.Fatalf("Since(%d) returned %d entries, want 2", applied[0].Revision, len(since))
+	}
+	if since[0].Revision != applied[1].Revision || since[1].Revision != applied[2].Revision {
+		t.Fatalf("Since(%d) = %+v, want entries for revisions %d and %d",
+			applied[0].Revision, since, applied[1].Revision, applied[2].Revision)
+	}
+
+	if got := log.Since(applied[2].Revision); len(got) != 0 {
+		t.Fatalf("Since(%d) = %+v, want no entries", applied[2].Revision, got)
+	}
+}
+
+func TestEncodeSnapshotWritesEntryCount(t *testing.T) {
+	log := NewLog()
+	entryA, _ := log.Apply(Command{MapID: "Route101", X: 1, Y: 1, Payload: []byte("a")})
+	entryB, _ := log.Apply(Command{MapID: "Route101", X: 2, Y: 2, Payload: []byte("bb")})
+
+	snapshot := EncodeSnapshot([]Entry{entryA, entryB})
+
+	count := uint32(snapshot[0]) | uint32(snapshot[1])<<8 | uint32(snapshot[2])<<16 | uint32(snapshot[3])<<24
+	if count != 2 {
+		t.Fatalf("snapshot entry count = %d, want 2", count)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger builds a *zap.Logger from the given level ("debug", "info",
+// "warn", "error"; defaults to "info") and format ("json" or "console";
+// defaults to "console").
+func NewLogger(level, format string) (*zap.Logger, error) {
+	if level == "" {
+		level = "info"
+	}
+	if format == "" {
+		format = "console"
+	}
+
+	var zapLevel zapcore.Level
+	if err := zapLevel.Set(level); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(zapLevel)
+	cfg.Encoding = format
+	if format == "console" {
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+
+	return cfg.Build()
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/huderlem/porymap-collab-server/internal/ot"
+)
+
+// Lifecycle message types, broadcast to session members as clients and
+// the session master come and go.
+const (
+	ServerMessagePeerJoined    = 0x5
+	ServerMessagePeerLeft      = 0x6
+	ServerMessageMasterChanged = 0x7
+)
+
+// ServerMessagePing is written periodically to each client to keep
+// NAT/firewall mappings alive and give half-open connections something
+// to time out on.
+const ServerMessagePing = 0x8
+
+// ServerMessageSnapshot answers a ClientMessageResync with every
+// accepted map edit since the requested revision.
+const ServerMessageSnapshot = 0x9
+
+// SessionRegistry guards the set of active sessions against concurrent
+// access from the many goroutines handling client connections.
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*session
+	logger   *zap.Logger
+}
+
+// NewSessionRegistry creates an empty SessionRegistry that logs through
+// logger.
+func NewSessionRegistry(logger *zap.Logger) *SessionRegistry {
+	return &SessionRegistry{
+		sessions: map[string]*session{},
+		logger:   logger,
+	}
+}
+
+// Create registers a new session named name with master as its first
+// client, and returns false if a session with that name already exists.
+func (r *SessionRegistry) Create(name string, token [tokenSize]byte, master *ClientConn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[name]; ok {
+		return false
+	}
+
+	master.SessionName = name
+	master.setSessionLogger(name)
+	s := &session{
+		token:   token,
+		clients: []*ClientConn{master},
+		master:  master,
+		logger:  r.logger.With(zap.String("session", name)),
+		otLog:   ot.NewLog(),
+	}
+	r.sessions[name] = s
+	s.logger.Info("Created new session")
+	return true
+}
+
+// Join adds client to the named session if token matches. It reports
+// whether the session exists, and whether the token was valid.
+func (r *SessionRegistry) Join(name string, token [tokenSize]byte, client *ClientConn) (exists bool, authorized bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[name]
+	if !ok {
+		return false, false
+	}
+	if token != s.token {
+		return true, false
+	}
+
+	for _, c := range s.clients {
+		if c == client {
+			return true, true
+		}
+	}
+
+	client.SessionName = name
+	client.setSessionLogger(name)
+	s.clients = append(s.clients, client)
+	s.logger.Info("Client joined session")
+	r.broadcastLocked(s, prepareServerMessage([]byte(remoteAddrOf(client)), ServerMessagePeerJoined), client)
+	return true, true
+}
+
+// Leave removes client from its session. If other clients remain and
+// client was the session master, a new master is promoted. If client was
+// the last member, the session is torn down.
+func (r *SessionRegistry) Leave(client *ClientConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[client.SessionName]
+	if !ok {
+		return
+	}
+
+	for i, c := range s.clients {
+		if c == client {
+			s.clients[i] = s.clients[len(s.clients)-1]
+			s.clients[len(s.clients)-1] = nil
+			s.clients = s.clients[:len(s.clients)-1]
+			break
+		}
+	}
+
+	if len(s.clients) == 0 {
+		delete(r.sessions, client.SessionName)
+		s.logger.Info("Session torn down", zap.Int("sessions_remaining", len(r.sessions)))
+		return
+	}
+
+	if client == s.master {
+		r.transferLocked(s, s.clients[0])
+	}
+
+	s.logger.Info("Removed client from session")
+	r.broadcastLocked(s, prepareServerMessage([]byte(remoteAddrOf(client)), ServerMessagePeerLeft), nil)
+}
+
+// Transfer promotes newMaster to master of its session. It is a no-op if
+// newMaster isn't a member of a session.
+func (r *SessionRegistry) Transfer(newMaster *ClientConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[newMaster.SessionName]
+	if !ok {
+		return
+	}
+	for _, c := range s.clients {
+		if c == newMaster {
+			r.transferLocked(s, newMaster)
+			return
+		}
+	}
+}
+
+// transferLocked promotes newMaster within s and notifies members.
+// Callers must hold r.mu.
+func (r *SessionRegistry) transferLocked(s *session, newMaster *ClientConn) {
+	s.master = newMaster
+	s.logger.Info("Promoted new session master")
+	r.broadcastLocked(s, prepareServerMessage([]byte(remoteAddrOf(newMaster)), ServerMessageMasterChanged), nil)
+}
+
+// Broadcast sends frame to every client in the named session except
+// exclude. It is a no-op if the session doesn't exist.
+func (r *SessionRegistry) Broadcast(sessionName string, frame []byte, exclude *ClientConn) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.sessions[sessionName]
+	if !ok {
+		return
+	}
+	r.broadcastLocked(s, frame, exclude)
+}
+
+// broadcastLocked queues frame for delivery to every client in s except
+// exclude. Each client's own writer goroutine drains its queue, so this
+// never blocks on a slow client. Callers must hold at least r.mu's read
+// lock.
+func (r *SessionRegistry) broadcastLocked(s *session, frame []byte, exclude *ClientConn) {
+	for _, c := range s.clients {
+		if c == exclude {
+			continue
+		}
+		c.enqueue(frame)
+	}
+}
+
+// ApplyCommand resolves cmd against the named session's revision log. It
+// reports exists=false if the session is gone, and accepted=false if
+// cmd's BaseRevision was stale relative to its target region and was
+// dropped.
+func (r *SessionRegistry) ApplyCommand(sessionName string, cmd ot.Command) (entry ot.Entry, accepted bool, exists bool) {
+	r.mu.RLock()
+	s, ok := r.sessions[sessionName]
+	r.mu.RUnlock()
+	if !ok {
+		return ot.Entry{}, false, false
+	}
+
+	entry, accepted = s.otLog.Apply(cmd)
+	return entry, accepted, true
+}
+
+// Since returns every entry accepted after rev in the named session. It
+// reports exists=false if the session is gone.
+func (r *SessionRegistry) Since(sessionName string, rev uint64) (entries []ot.Entry, exists bool) {
+	r.mu.RLock()
+	s, ok := r.sessions[sessionName]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	return s.otLog.Since(rev), true
+}
+
+func remoteAddrOf(c *ClientConn) string {
+	if c == nil || c.Conn == nil {
+		return ""
+	}
+	return c.Conn.RemoteAddr().String()
+}
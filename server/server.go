@@ -0,0 +1,293 @@
+// Package server implements the Porymap collaboration server, which
+// relays map-editing commands between connected porymap clients that
+// have joined the same session.
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/huderlem/porymap-collab-server/internal/ot"
+)
+
+var clientMessageSignature uint32 = 0x12345678
+var serverMessageSignature uint32 = 0x98765432
+
+// Client message types.
+const (
+	ClientMessageStartSession = 0x1
+	ClientMessageJoinSession  = 0x2
+	ClientMessageBroadcast    = 0x3
+	ClientMessageResync       = 0x4
+)
+
+// Server message types.
+const (
+	ServerMessageCreatedSession   = 0x1
+	ServerMessageJoinedSession    = 0x2
+	ServerMessageBroadcastCommand = 0x3
+	ServerMessageError            = 0x4
+)
+
+const (
+	// defaultWriteQueueSize is the default number of outbound frames
+	// buffered per client before it is considered too slow and evicted.
+	defaultWriteQueueSize = 256
+
+	// defaultPingInterval is the default interval at which clients are
+	// pinged. The read deadline enforced on each client's connection is
+	// twice this, so a single missed ping doesn't cause a disconnect.
+	defaultPingInterval = 30 * time.Second
+)
+
+// Config holds the runtime configuration used to start the server.
+type Config struct {
+	Port string
+
+	// TLSCertPath, TLSKeyPath, and TLSClientCA configure an optional TLS
+	// listener. When TLSCertPath and TLSKeyPath are both empty, the
+	// server listens with plain TCP. When TLSClientCA is also set,
+	// clients must present a certificate signed by it.
+	TLSCertPath string
+	TLSKeyPath  string
+	TLSClientCA string
+
+	// Logger receives all server log output. Callers should build one
+	// with NewLogger, or pass zap.NewNop() to discard logs.
+	Logger *zap.Logger
+
+	// WriteQueueSize bounds how many outbound frames may be queued per
+	// client before it is considered too slow and evicted. Defaults to
+	// defaultWriteQueueSize.
+	WriteQueueSize int
+
+	// PingInterval controls how often a ServerMessagePing frame is sent
+	// to each client. Defaults to defaultPingInterval.
+	PingInterval time.Duration
+}
+
+// Serve starts the collab server and blocks, accepting connections until
+// the listener fails.
+func Serve(cfg Config) error {
+	l, err := cfg.listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	logger := cfg.Logger
+	registry := NewSessionRegistry(logger)
+
+	writeQueueSize := cfg.WriteQueueSize
+	if writeQueueSize == 0 {
+		writeQueueSize = defaultWriteQueueSize
+	}
+	pingInterval := cfg.PingInterval
+	if pingInterval == 0 {
+		pingInterval = defaultPingInterval
+	}
+
+	logger.Info("Starting Porymap collab server", zap.String("port", cfg.Port))
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			logger.Error("Accept failed", zap.Error(err))
+			return err
+		}
+		go handleConnection(c, logger, registry, writeQueueSize, pingInterval)
+	}
+}
+
+func handleConnection(conn net.Conn, logger *zap.Logger, registry *SessionRegistry, writeQueueSize int, pingInterval time.Duration) {
+	remoteAddr := conn.RemoteAddr().String()
+	logger = logger.With(zap.String("remote_addr", remoteAddr))
+	logger.Info("Serving new client")
+	readBuffer := make([]byte, 4096)
+	workBuffer := new(bytes.Buffer)
+	client := newClientConn(conn, logger, registry, writeQueueSize)
+	go client.writeLoop(pingInterval)
+
+	readDeadline := 2 * pingInterval
+	for {
+		conn.SetReadDeadline(time.Now().Add(readDeadline))
+		numBytes, err := conn.Read(readBuffer)
+		if err != nil {
+			logger.Info("Connection closed by client", zap.Error(err))
+			break
+		}
+
+		if numBytes == 0 {
+			continue
+		}
+
+		workBuffer.Write(readBuffer[:numBytes])
+		terminate := client.processMessageBuffer(workBuffer)
+		if terminate {
+			break
+		}
+	}
+	logger.Info("Client disconnected")
+	client.Close()
+	registry.Leave(client)
+}
+
+func (client *ClientConn) processMessageBuffer(buff *bytes.Buffer) bool {
+	for {
+		if buff.Len() < 12 {
+			return false
+		}
+
+		signature := binary.LittleEndian.Uint32(buff.Bytes()[:4])
+		if signature != clientMessageSignature {
+			client.Logger().Warn("Incorrect message signature. Disconnecting...")
+			return true
+		}
+
+		payloadSize := binary.LittleEndian.Uint32(buff.Bytes()[4:8])
+		messageSize := int(12 + payloadSize)
+		if buff.Len() < messageSize {
+			return false
+		}
+
+		messageType := binary.LittleEndian.Uint32(buff.Bytes()[8:12])
+		message := buff.Next(messageSize)
+		client.processMessage(messageType, message[12:])
+	}
+}
+
+func (client *ClientConn) processMessage(messageType uint32, message []byte) {
+	logger := client.Logger().With(
+		zap.Uint32("message_type", messageType),
+		zap.Int("payload_size", len(message)),
+	)
+	switch messageType {
+	case ClientMessageStartSession:
+		token, sessionName, ok := splitTokenAndSessionName(message)
+		if !ok {
+			logger.Warn("Malformed start session message")
+			return
+		}
+		if !client.Registry.Create(sessionName, token, client) {
+			logger.Info("Session already exists", zap.String("session", sessionName))
+		}
+	case ClientMessageJoinSession:
+		token, sessionName, ok := splitTokenAndSessionName(message)
+		if !ok {
+			logger.Warn("Malformed join session message")
+			return
+		}
+		exists, authorized := client.Registry.Join(sessionName, token, client)
+		if !exists {
+			logger.Info("Session doesn't exist", zap.String("session", sessionName))
+			return
+		}
+		if !authorized {
+			logger.Warn("Rejected join: invalid token", zap.String("session", sessionName))
+			client.closeWithError("invalid session token")
+			return
+		}
+	case ClientMessageBroadcast:
+		// Applied synchronously and in receive order: the revision-based
+		// conflict resolution in broadcastCommand depends on commands
+		// being applied to the revision log in the order the client
+		// issued them. Recipient writes are already non-blocking
+		// (ClientConn's queued writer), so there's nothing to gain by
+		// handling this off the read goroutine.
+		client.broadcastCommand(message)
+	case ClientMessageResync:
+		client.handleResync(message)
+	}
+}
+
+// splitTokenAndSessionName parses the `[16-byte token][session name]`
+// payload shared by the start-session and join-session messages.
+func splitTokenAndSessionName(message []byte) (token [tokenSize]byte, sessionName string, ok bool) {
+	if len(message) < tokenSize {
+		return token, "", false
+	}
+	copy(token[:], message[:tokenSize])
+	return token, string(message[tokenSize:]), true
+}
+
+// closeWithError writes a ServerMessageError frame with the given
+// human-readable reason directly to the connection, then closes it. The
+// write queue is bypassed and the write is synchronous so the frame is
+// guaranteed to go out before the connection closes, rather than racing
+// the writer goroutine against Close.
+func (client *ClientConn) closeWithError(reason string) {
+	frame := prepareServerMessage([]byte(reason), ServerMessageError)
+	if _, err := client.Conn.Write(frame); err != nil {
+		client.Logger().Warn("Failed to send error message", zap.Error(err))
+	}
+	client.Close()
+}
+
+func prepareServerMessage(message []byte, messageType int) []byte {
+	header := []byte{
+		byte(serverMessageSignature & 0xFF),
+		byte((serverMessageSignature >> 8) & 0xFF),
+		byte((serverMessageSignature >> 16) & 0xFF),
+		byte((serverMessageSignature >> 24) & 0xFF),
+		byte(len(message) & 0xFF),
+		byte((len(message) >> 8) & 0xFF),
+		byte((len(message) >> 16) & 0xFF),
+		byte((len(message) >> 24) & 0xFF),
+		byte(messageType & 0xFF),
+		byte((messageType >> 8) & 0xFF),
+		byte((messageType >> 16) & 0xFF),
+		byte((messageType >> 24) & 0xFF),
+	}
+	return append(header, message...)
+}
+
+// broadcastCommand decodes message as a structured map-edit command and
+// resolves it against the session's revision log. Commands whose
+// BaseRevision is stale relative to the region they target are dropped
+// rather than rebroadcast, so two clients editing the same tile at once
+// converge on the edit the server accepted first instead of diverging.
+// Accepted commands are tagged with their assigned revision and relayed
+// to every other client in the session.
+func (client *ClientConn) broadcastCommand(message []byte) {
+	cmd, err := ot.Decode(message)
+	if err != nil {
+		client.Logger().Warn("Dropping malformed map edit command", zap.Error(err))
+		return
+	}
+
+	entry, accepted, exists := client.Registry.ApplyCommand(client.SessionName, cmd)
+	if !exists {
+		return
+	}
+	if !accepted {
+		client.Logger().Info("Dropping stale map edit command",
+			zap.String("map_id", cmd.MapID),
+			zap.Uint64("base_revision", cmd.BaseRevision))
+		return
+	}
+
+	frame := prepareServerMessage(ot.EncodeBroadcast(entry), ServerMessageBroadcastCommand)
+	client.Registry.Broadcast(client.SessionName, frame, client)
+}
+
+// handleResync answers a ClientMessageResync, whose payload is an
+// 8-byte little-endian revision, with a ServerMessageSnapshot containing
+// every command accepted since that revision. This lets a collaborator
+// who dropped off Wi-Fi catch up without restarting the session.
+func (client *ClientConn) handleResync(message []byte) {
+	if len(message) < 8 {
+		client.Logger().Warn("Malformed resync request")
+		return
+	}
+	since := binary.LittleEndian.Uint64(message[:8])
+
+	entries, exists := client.Registry.Since(client.SessionName, since)
+	if !exists {
+		return
+	}
+
+	client.enqueue(prepareServerMessage(ot.EncodeSnapshot(entries), ServerMessageSnapshot))
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/huderlem/porymap-collab-server/internal/ot"
+)
+
+// tokenSize is the number of bytes in a session access token.
+const tokenSize = 16
+
+// ClientConn holds the client connection details.
+type ClientConn struct {
+	Conn        net.Conn
+	SessionName string
+	Registry    *SessionRegistry
+
+	// logger is swapped, not mutated, so it can be read concurrently by
+	// the writer goroutine while processMessage attaches the session
+	// name once the client creates or joins one.
+	logger atomic.Pointer[zap.Logger]
+
+	// writeCh is drained by a dedicated writer goroutine so that a slow
+	// client can't pile up writer goroutines or interleave partial
+	// writes on the connection.
+	writeCh   chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newClientConn creates a ClientConn with its write queue ready to use.
+// The caller must start writeLoop in its own goroutine.
+func newClientConn(conn net.Conn, logger *zap.Logger, registry *SessionRegistry, writeQueueSize int) *ClientConn {
+	client := &ClientConn{
+		Conn:     conn,
+		Registry: registry,
+		writeCh:  make(chan []byte, writeQueueSize),
+		done:     make(chan struct{}),
+	}
+	client.logger.Store(logger)
+	return client
+}
+
+// Logger returns the client's current logger. Safe for concurrent use.
+func (client *ClientConn) Logger() *zap.Logger {
+	return client.logger.Load()
+}
+
+// setSessionLogger attaches sessionName to the client's logger so every
+// subsequent log line from this client can be grepped by session.
+func (client *ClientConn) setSessionLogger(sessionName string) {
+	client.logger.Store(client.logger.Load().With(zap.String("session", sessionName)))
+}
+
+// enqueue attempts a non-blocking send of frame to the client's write
+// queue. If the queue is full, the client is too slow to keep up and is
+// evicted: its connection is closed, which unwinds its read loop and lets
+// the registry clean it up.
+func (client *ClientConn) enqueue(frame []byte) {
+	select {
+	case client.writeCh <- frame:
+	default:
+		client.Logger().Warn("Client write queue full, evicting")
+		client.Close()
+	}
+}
+
+// Close closes the client's connection and stops its writer goroutine.
+// It is safe to call multiple times.
+func (client *ClientConn) Close() {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		client.Conn.Close()
+	})
+}
+
+// session represents a single active porymap collaboration session.
+type session struct {
+	token   [tokenSize]byte
+	clients []*ClientConn
+	master  *ClientConn
+	logger  *zap.Logger
+	otLog   *ot.Log
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// listen opens the listening socket for the server, upgrading to TLS when
+// a certificate and key are configured. When TLSClientCA is also set,
+// clients must present a certificate signed by that CA (mutual TLS).
+func (cfg Config) listen() (net.Listener, error) {
+	if cfg.TLSCertPath == "" && cfg.TLSKeyPath == "" {
+		return net.Listen("tcp", ":"+cfg.Port)
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS cert/key: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.TLSClientCA != "" {
+		caCert, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA file %s", cfg.TLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tls.Listen("tcp", ":"+cfg.Port, tlsConfig)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeLoop drains client's write queue onto its connection and writes a
+// ServerMessagePing frame every pingInterval to keep NAT/firewall
+// mappings alive and give the reader side something to time out on if a
+// connection goes half-open.
+func (client *ClientConn) writeLoop(pingInterval time.Duration) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	pingFrame := prepareServerMessage(nil, ServerMessagePing)
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case frame := <-client.writeCh:
+			if _, err := client.Conn.Write(frame); err != nil {
+				client.Logger().Warn("Failed to write message", zap.Error(err))
+				client.Close()
+				return
+			}
+		case <-ticker.C:
+			if _, err := client.Conn.Write(pingFrame); err != nil {
+				client.Logger().Warn("Failed to write ping", zap.Error(err))
+				client.Close()
+				return
+			}
+		}
+	}
+}